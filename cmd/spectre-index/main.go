@@ -0,0 +1,132 @@
+// Command spectre-index builds or adds to a persistent fingerprint.Index
+// from a directory of audio files.
+//
+// Usage:
+//   spectre-index -db index.db -dir /path/to/audio
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/snuffpuppet/spectre/fingerprint"
+	"github.com/snuffpuppet/spectre/pcm"
+)
+
+var fingerprintBucket = []byte("fingerprints")
+
+// boltStore adapts a bolt.DB to fingerprint.KVStore.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(fingerprintBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Get(key []byte) (value []byte, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(fingerprintBucket).Get(key); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return
+}
+
+func (s *boltStore) Put(key, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(fingerprintBucket).Put(key, value)
+	})
+}
+
+// Batch satisfies fingerprint.BatchWriter, wrapping every put fn makes in a
+// single bolt transaction instead of one per fingerprint.
+func (s *boltStore) Batch(fn func(put func(key, value []byte) error) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(fingerprintBucket).Put)
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func main() {
+	dbPath := flag.String("db", "spectre.db", "path to the index database")
+	dir := flag.String("dir", "", "directory of audio files to add to the index")
+	sampleRate := flag.Int("rate", 11025, "sample rate to decode audio at")
+	flag.Parse()
+
+	if *dir == "" {
+		log.Fatal("spectre-index: -dir is required")
+	}
+
+	store, err := openBoltStore(*dbPath)
+	if err != nil {
+		log.Fatalf("spectre-index: opening %s: %v", *dbPath, err)
+	}
+	defer store.Close()
+
+	idx := fingerprint.NewIndex(store)
+
+	err = filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !isAudioFile(path) {
+			return err
+		}
+
+		return addFile(idx, path, *sampleRate)
+	})
+	if err != nil {
+		log.Fatalf("spectre-index: %v", err)
+	}
+}
+
+func isAudioFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".wav", ".mp3", ".flac":
+		return true
+	}
+	return false
+}
+
+func addFile(idx *fingerprint.Index, path string, sampleRate int) error {
+	blocks, err := pcm.LoadFile(path, sampleRate)
+	if err != nil {
+		return err
+	}
+
+	var fps []*fingerprint.Fingerprint
+	for _, block := range blocks {
+		fp := fingerprint.New(block, sampleRate, fingerprint.SA_BESPOKE, fingerprint.FP_TRANSCRIBE, fingerprint.WINDOW_HANN, nil, false)
+		if fp == nil {
+			continue
+		}
+		fps = append(fps, fp)
+	}
+
+	if err := idx.AddBatch(fps, filepath.Base(path)); err != nil {
+		return err
+	}
+
+	log.Printf("spectre-index: %s: added %d fingerprints", path, len(fps))
+	return nil
+}
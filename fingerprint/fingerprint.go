@@ -24,6 +24,15 @@ const (
 	SA_BESPOKE = iota
 )
 
+// Key generation methods available to New, mirroring the SA_* spectral
+// analyser constants above.
+const (
+	_ = iota
+	FP_TRANSCRIBE = iota
+	FP_TOPFREQS = iota
+	FP_FREQBANDS = iota
+	FP_PANAKO = iota // Panako-style shift-invariant triplet hashing, see panako.go
+)
 
 /*
  * Spectral Analysis and fingerprinting:
@@ -64,6 +73,10 @@ type Fingerprint struct {
 	Timestamp     float64
 	Candidates    candidates
 	Transcription Transcription
+	Peaks         []Peak  // populated by the FP_PANAKO method, nil otherwise
+	Gain          float64 // linear gain applied to reach TARGET_LUFS before analysis
+	Loudness      float64 // measured integrated loudness of the buffer, in LUFS
+	Peak          float64 // measured peak sample value, pre-normalisation
 }
 
 // For the Chroma identification method of matching:
@@ -167,6 +180,8 @@ func fuzzyFreq(f float64) float64 {
 
 // Convert the frequency/power data into buckets of musical notes based on strength of signal
 func transcribe(freqs, Pxx []float64) (t Transcription) {
+	freqs, Pxx = peaksToSlices(refinePeaks(freqs, Pxx))
+
 	chromaCount := 0
 	t = make([]Chroma, MAX_NOTE)
 	for i, v := range freqs {
@@ -229,6 +244,8 @@ func audioKey(t Transcription) (key []byte) {
 
 // return te strongest (REQUIRED_CANDIDATES) frequencies in the frequency data
 func getTopCandidates(freqs, Pxx []float64) (candidates) {
+	freqs, Pxx = peaksToSlices(refinePeaks(freqs, Pxx))
+
 	candidates := make([]candidate, 0)
 
 	// select only those stronger than the power threshold and higher than the frequency threshold
@@ -259,6 +276,7 @@ func getTopCandidates(freqs, Pxx []float64) (candidates) {
 // Use a basic frequency banding method for classifying frequencies and choosing candidates for the fingerprint
 // Return the strongest frequency in each of four bands ordered by strength
 func getBandedCandidates(freqs, Pxx []float64) (candidates) {
+	freqs, Pxx = peaksToSlices(refinePeaks(freqs, Pxx))
 
 	candidates := make([]candidate, 0)
 	highScores := make(map[int]float64)
@@ -339,7 +357,20 @@ func logSamples(verbose bool, freqs, Pxx []float64) {
 	}
 }
 
-func New(sampleBlock *pcm.Buffer, sampleRate int, optSpectralAnalyser int, optVerbose bool) (*Fingerprint) {
+// New builds a Fingerprint for one block of audio. optMethod selects between
+// the FP_* key generation methods; picker is only consulted for FP_PANAKO
+// (pass nil for every other method) and must be a *PeakPicker owned by the
+// caller for the single audio source being fingerprinted - sharing one
+// across unrelated sources would slide their frames into the same ring
+// buffer and hash bogus cross-source triplets.
+func New(sampleBlock *pcm.Buffer, sampleRate int, optSpectralAnalyser int, optMethod int, optWindow int, picker *PeakPicker, optVerbose bool) (*Fingerprint) {
+	gain, loudness, peak := normalizeGain(sampleBlock.Samples, sampleRate)
+	normalised := make([]float64, len(sampleBlock.Samples))
+	for i, s := range sampleBlock.Samples {
+		normalised[i] = s * gain
+	}
+	sampleBlock = &pcm.Buffer{Samples: preprocess(normalised, optWindow), Timestamp: sampleBlock.Timestamp}
+
 	var Pxx, freqs []float64
 	switch optSpectralAnalyser {
 	case SA_PWELCH:
@@ -350,15 +381,13 @@ func New(sampleBlock *pcm.Buffer, sampleRate int, optSpectralAnalyser int, optVe
 		log.Panicf("Unrecognised spectral analyser %d\n", optSpectralAnalyser)
 	}
 
-	optMethod :=  "transcribe" //"freqbands" // "transcribe", "topfreq"
-
 	//logSamples(optVerbose, freqs, Pxx)
 
 	var key []byte
 	var fp Fingerprint
 
-	switch (optMethod) {
-	case "transcribe":
+	switch optMethod {
+	case FP_TRANSCRIBE:
 		transcription := transcribe(freqs, Pxx)
 		//log.Printf("fp transscription: %s\n", transcription)
 
@@ -374,7 +403,7 @@ func New(sampleBlock *pcm.Buffer, sampleRate int, optSpectralAnalyser int, optVe
 			Candidates: nil,
 			Transcription: transcription,
 		}
-	case "topfreqs":
+	case FP_TOPFREQS:
 		candidates := getTopCandidates(freqs, Pxx)
 
 		if len(candidates) < REQUIRED_CANDIDATES {
@@ -395,7 +424,7 @@ func New(sampleBlock *pcm.Buffer, sampleRate int, optSpectralAnalyser int, optVe
 			Candidates: candidates,
 			Transcription: nil,
 		}
-	case "freqbands":
+	case FP_FREQBANDS:
 		candidates := getBandedCandidates(freqs, Pxx)
 
 		if len(candidates) < REQUIRED_CANDIDATES {
@@ -416,10 +445,31 @@ func New(sampleBlock *pcm.Buffer, sampleRate int, optSpectralAnalyser int, optVe
 			Candidates: candidates,
 			Transcription: nil,
 		}
+	case FP_PANAKO:
+		// A single AddFrame call only ever yields peaks at one TimeFrame
+		// (the middle of the max-filter window), so there's no triplet to
+		// hash yet - Matcher.Add/Query accumulate Peaks across many
+		// Fingerprints before triplets() has enough distinct time frames
+		// to work with.
+		peaks := picker.AddFrame(freqs, Pxx)
+		if len(peaks) == 0 {
+			return nil // window not yet full, or no local maximum in its middle frame
+		}
+
+		fp = Fingerprint{
+			Key: nil,
+			Timestamp: sampleBlock.Timestamp,
+			Candidates: nil,
+			Transcription: nil,
+			Peaks: peaks,
+		}
 	default:
-		log.Panicf("Fingerprint: Unknown key generaion method: %s", optMethod)
+		log.Panicf("Fingerprint: Unknown key generaion method: %d", optMethod)
 	}
 
+	fp.Gain = gain
+	fp.Loudness = loudness
+	fp.Peak = peak
 
 	return &fp
 }
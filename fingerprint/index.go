@@ -0,0 +1,194 @@
+package fingerprint
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+)
+
+// INDEX_HISTOGRAM_BIN is the tolerance, in seconds, when binning the time
+// offset between a query fingerprint and a candidate Mapping.
+const INDEX_HISTOGRAM_BIN = 0.2
+
+// INDEX_MIN_HITS is the minimum histogram peak count required before a
+// candidate file is reported as a Match.
+const INDEX_MIN_HITS = 4
+
+// KVStore is the minimal interface an on-disk key/value store must satisfy
+// to back an Index. A bolt.DB or badger.DB can be wrapped to satisfy this.
+type KVStore interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Close() error
+}
+
+// BatchWriter is optionally implemented by a KVStore backend that can group
+// multiple writes into a single underlying transaction (e.g. bolt.DB.Update
+// wrapping one transaction around many bucket.Put calls). AddBatch uses it
+// when available instead of one transaction per fingerprint.
+type BatchWriter interface {
+	Batch(fn func(put func(key, value []byte) error) error) error
+}
+
+// Index is a persistent inverted index from a fingerprint Key to every
+// Mapping (Filename, Timestamp) it was seen at, backed by a pluggable
+// KVStore.
+type Index struct {
+	store KVStore
+}
+
+func NewIndex(store KVStore) *Index {
+	return &Index{store: store}
+}
+
+// Add indexes a single fingerprint against filename, merging with any
+// mappings already stored under the same key.
+func (idx *Index) Add(fp *Fingerprint, filename string) error {
+	if fp == nil {
+		return nil
+	}
+
+	existing, err := idx.lookup(fp.Key)
+	if err != nil {
+		return err
+	}
+	existing = append(existing, Mapping{Filename: filename, Timestamp: fp.Timestamp})
+
+	return idx.store.Put(fp.Key, encodeMappings(existing))
+}
+
+// AddBatch indexes every fingerprint in fps against filename. When the
+// underlying KVStore implements BatchWriter, every write is grouped into
+// one transaction; otherwise it falls back to one transaction per
+// fingerprint via Add.
+func (idx *Index) AddBatch(fps []*Fingerprint, filename string) error {
+	batcher, ok := idx.store.(BatchWriter)
+	if !ok {
+		for _, fp := range fps {
+			if err := idx.Add(fp, filename); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Accumulate mappings per key in memory before writing, one read+write
+	// per distinct key: idx.lookup goes through idx.store.Get, a separate
+	// read transaction from the db.Update this Batch runs inside, so it
+	// would never see a Put made earlier in this same call.
+	merged := make(map[string][]Mapping)
+	keys := make(map[string][]byte)
+
+	return batcher.Batch(func(put func(key, value []byte) error) error {
+		for _, fp := range fps {
+			if fp == nil {
+				continue
+			}
+
+			k := string(fp.Key)
+			if _, ok := merged[k]; !ok {
+				existing, err := idx.lookup(fp.Key)
+				if err != nil {
+					return err
+				}
+				merged[k] = existing
+				keys[k] = fp.Key
+			}
+
+			merged[k] = append(merged[k], Mapping{Filename: filename, Timestamp: fp.Timestamp})
+		}
+
+		for k, mappings := range merged {
+			if err := put(keys[k], encodeMappings(mappings)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (idx *Index) lookup(key []byte) ([]Mapping, error) {
+	raw, err := idx.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	return decodeMappings(raw)
+}
+
+func encodeMappings(m []Mapping) []byte {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(m) // encoding a []Mapping of basic types, err is always nil
+	return buf.Bytes()
+}
+
+func decodeMappings(raw []byte) ([]Mapping, error) {
+	var m []Mapping
+	err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&m)
+	return m, err
+}
+
+// Query performs the standard landmark-matching lookup: for every
+// fingerprint in fps, look up candidate Mappings by Key, compute
+// dt = candidate.Timestamp - query.Timestamp, and histogram dt per
+// Filename. Files whose peak histogram bin reaches INDEX_MIN_HITS are
+// returned as Matches, strongest first.
+func (idx *Index) Query(fps []*Fingerprint) ([]Match, error) {
+	histograms := make(map[string]map[int]int)
+
+	for _, fp := range fps {
+		if fp == nil {
+			continue
+		}
+		candidates, err := idx.lookup(fp.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range candidates {
+			bin := int((c.Timestamp - fp.Timestamp) / INDEX_HISTOGRAM_BIN)
+
+			hist, ok := histograms[c.Filename]
+			if !ok {
+				hist = make(map[int]int)
+				histograms[c.Filename] = hist
+			}
+			hist[bin]++
+		}
+	}
+
+	var matches []Match
+	for filename, hist := range histograms {
+		var bestBin, bestCount int
+		for bin, count := range hist {
+			if count > bestCount {
+				bestBin, bestCount = bin, count
+			}
+		}
+		if bestCount < INDEX_MIN_HITS {
+			continue
+		}
+
+		matches = append(matches, Match{
+			SourceID:   filename,
+			Confidence: float64(bestCount) / float64(len(fps)),
+			TimeOffset: float64(bestBin) * INDEX_HISTOGRAM_BIN,
+			// Key lookup carries no triplet-ratio structure to estimate a
+			// stretch/shift factor from (unlike Matcher's triplet hashes),
+			// so there is nothing to recover here - 1.0 is a true "none
+			// detected", not a placeholder.
+			TimeFactor: 1.0,
+			FreqFactor: 1.0,
+		})
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Confidence > matches[j].Confidence })
+
+	return matches, nil
+}
+
+func (idx *Index) Close() error {
+	return idx.store.Close()
+}
@@ -0,0 +1,54 @@
+package fingerprint
+
+import "testing"
+
+// fakeBatchStore is a minimal in-memory KVStore + BatchWriter, enough to
+// exercise AddBatch's batched code path without a real bolt.DB.
+type fakeBatchStore struct {
+	data map[string][]byte
+}
+
+func newFakeBatchStore() *fakeBatchStore {
+	return &fakeBatchStore{data: make(map[string][]byte)}
+}
+
+func (s *fakeBatchStore) Get(key []byte) ([]byte, error) {
+	return s.data[string(key)], nil
+}
+
+func (s *fakeBatchStore) Put(key, value []byte) error {
+	s.data[string(key)] = value
+	return nil
+}
+
+func (s *fakeBatchStore) Close() error { return nil }
+
+func (s *fakeBatchStore) Batch(fn func(put func(key, value []byte) error) error) error {
+	return fn(s.Put)
+}
+
+// TestAddBatchMergesDuplicateKeysWithinOneBatch guards against AddBatch
+// reading through a stale snapshot for fingerprints that share a Key within
+// the same batch, which previously dropped all but the last mapping.
+func TestAddBatchMergesDuplicateKeysWithinOneBatch(t *testing.T) {
+	store := newFakeBatchStore()
+	idx := NewIndex(store)
+
+	key := []byte("dup-key")
+	fps := []*Fingerprint{
+		{Key: key, Timestamp: 0.0},
+		{Key: key, Timestamp: 1.0},
+	}
+
+	if err := idx.AddBatch(fps, "source.wav"); err != nil {
+		t.Fatalf("AddBatch: %v", err)
+	}
+
+	mappings, err := idx.lookup(key)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if len(mappings) != 2 {
+		t.Fatalf("expected both mappings to be merged, got %d: %+v", len(mappings), mappings)
+	}
+}
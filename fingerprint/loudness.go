@@ -0,0 +1,164 @@
+package fingerprint
+
+import (
+	"math"
+)
+
+// ReplayGain / EBU R128 style loudness normalisation, applied to a sample
+// buffer before spectral analysis so that LOWER_POWER_CUTOFF means roughly
+// the same thing regardless of a source recording's level.
+// ref: https://tech.ebu.ch/docs/tech/tech3341.pdf
+
+const (
+	TARGET_LUFS   = -18.0 // reference loudness that buffers are normalised to
+	GATE_BLOCK_SEC = 0.4  // EBU R128 gating block size
+	ABSOLUTE_GATE = -70.0 // LUFS; blocks quieter than this are ignored
+	RELATIVE_GATE = -10.0 // LU below the (gated) mean; second gating pass
+)
+
+// biquad applies a standard difference-equation biquad filter to x and
+// returns the result as a new slice, leaving x unchanged; coefficients are in
+// the usual a0=1 normalised form.
+func biquad(x []float64, b0, b1, b2, a1, a2 float64) []float64 {
+	y := make([]float64, len(x))
+	var x1, x2, y1, y2 float64
+	for i, xi := range x {
+		yi := b0*xi + b1*x1 + b2*x2 - a1*y1 - a2*y2
+		y[i] = yi
+		x2, x1 = x1, xi
+		y2, y1 = y1, yi
+	}
+	return y
+}
+
+// kWeight applies the BS.1770 K-weighting curve: a high-frequency shelf
+// boost (modelling head diffraction) followed by an RLB high-pass. Both
+// stages are analog RLB/shelf prototypes re-derived via the bilinear
+// transform at the actual sampleRate - naively scaling a fixed 48kHz
+// coefficient set doesn't track the frequency warp of the transform, and
+// pushes the poles outside the unit circle (unstable filter) at most other
+// sample rates.
+func kWeight(samples []float64, sampleRate int) []float64 {
+	sb0, sb1, sb2, sa1, sa2 := shelfCoeffs(float64(sampleRate))
+	shelved := biquad(samples, sb0, sb1, sb2, sa1, sa2)
+
+	hb0, hb1, hb2, ha1, ha2 := highPassCoeffs(float64(sampleRate))
+	return biquad(shelved, hb0, hb1, hb2, ha1, ha2)
+}
+
+// shelfCoeffs derives the BS.1770 pre-filter (a high shelf modelling head
+// diffraction) for sampleRate from its analog prototype via the bilinear
+// transform.
+func shelfCoeffs(sampleRate float64) (b0, b1, b2, a1, a2 float64) {
+	const f0 = 1681.974450955533
+	const gainDB = 3.999843853973347
+	const q = 0.7071752369554196
+
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	vh := math.Pow(10, gainDB/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+
+	a0 := 1 + k/q + k*k
+	b0 = (vh + vb*k/q + k*k) / a0
+	b1 = 2 * (k*k - vh) / a0
+	b2 = (vh - vb*k/q + k*k) / a0
+	a1 = 2 * (k*k - 1) / a0
+	a2 = (1 - k/q + k*k) / a0
+	return
+}
+
+// highPassCoeffs derives the BS.1770 RLB high-pass for sampleRate from its
+// analog prototype via the bilinear transform.
+func highPassCoeffs(sampleRate float64) (b0, b1, b2, a1, a2 float64) {
+	const f0 = 38.13547087613982
+	const q = 0.5003270373238773
+
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	a0 := 1 + k/q + k*k
+	b0 = 1 / a0
+	b1 = -2 / a0
+	b2 = 1 / a0
+	a1 = 2 * (k*k - 1) / a0
+	a2 = (1 - k/q + k*k) / a0
+	return
+}
+
+// gatedLoudnessLUFS implements the ITU-R BS.1770 gated integration: mean
+// square energy over GATE_BLOCK_SEC blocks, with an absolute gate followed
+// by a relative gate at RELATIVE_GATE below the once-gated mean.
+func gatedLoudnessLUFS(weighted []float64, sampleRate int) float64 {
+	blockLen := int(GATE_BLOCK_SEC * float64(sampleRate))
+	if blockLen <= 0 || len(weighted) < blockLen {
+		blockLen = len(weighted)
+	}
+	if blockLen == 0 {
+		return ABSOLUTE_GATE
+	}
+
+	var blockLoudness []float64
+	for start := 0; start+blockLen <= len(weighted); start += blockLen {
+		var sumSq float64
+		for _, v := range weighted[start : start+blockLen] {
+			sumSq += v * v
+		}
+		meanSq := sumSq / float64(blockLen)
+		if meanSq <= 0 {
+			continue
+		}
+
+		lufs := -0.691 + 10*math.Log10(meanSq)
+		if lufs > ABSOLUTE_GATE {
+			blockLoudness = append(blockLoudness, lufs)
+		}
+	}
+
+	if len(blockLoudness) == 0 {
+		return ABSOLUTE_GATE
+	}
+
+	ungated := meanLUFS(blockLoudness)
+
+	var gated []float64
+	for _, l := range blockLoudness {
+		if l > ungated+RELATIVE_GATE {
+			gated = append(gated, l)
+		}
+	}
+	if len(gated) == 0 {
+		return ungated
+	}
+
+	return meanLUFS(gated)
+}
+
+func meanLUFS(blocks []float64) float64 {
+	var sumPower float64
+	for _, l := range blocks {
+		sumPower += math.Pow(10, (l+0.691)/10)
+	}
+	return -0.691 + 10*math.Log10(sumPower/float64(len(blocks)))
+}
+
+// measureLoudness returns the integrated loudness (LUFS) and sample peak
+// (largest absolute sample value, linear, 0..1+) of samples.
+func measureLoudness(samples []float64, sampleRate int) (lufs, peak float64) {
+	lufs = gatedLoudnessLUFS(kWeight(samples, sampleRate), sampleRate)
+
+	for _, v := range samples {
+		if math.Abs(v) > peak {
+			peak = math.Abs(v)
+		}
+	}
+
+	return
+}
+
+// normalizeGain measures samples and returns the linear gain factor that
+// would bring it to TARGET_LUFS, along with the measurement it was derived
+// from.
+func normalizeGain(samples []float64, sampleRate int) (gain, measuredLUFS, peak float64) {
+	measuredLUFS, peak = measureLoudness(samples, sampleRate)
+	gainDB := TARGET_LUFS - measuredLUFS
+
+	return math.Pow(10, gainDB/20), measuredLUFS, peak
+}
@@ -0,0 +1,48 @@
+package fingerprint
+
+import (
+	"math"
+	"testing"
+)
+
+// TestKWeightStable guards against a naive per-sample-rate coefficient
+// scaling that looked plausible but pushed the filter's poles outside the
+// unit circle at every rate except 48000 Hz, diverging to +Inf/NaN within a
+// couple thousand samples.
+func TestKWeightStable(t *testing.T) {
+	for _, sampleRate := range []int{48000, 44100, 22050, 11025, 8000} {
+		samples := make([]float64, 20000)
+		for i := range samples {
+			samples[i] = math.Sin(2 * math.Pi * 440.0 * float64(i) / float64(sampleRate))
+		}
+
+		weighted := kWeight(samples, sampleRate)
+		for i, v := range weighted {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				t.Fatalf("sampleRate=%d: kWeight diverged at sample %d: %v", sampleRate, i, v)
+			}
+			if math.Abs(v) > 10 {
+				t.Fatalf("sampleRate=%d: kWeight output unreasonably large at sample %d: %v", sampleRate, i, v)
+			}
+		}
+	}
+}
+
+// TestNormalizeGainAtProjectDefaultRate guards against gatedLoudnessLUFS
+// falling through to ABSOLUTE_GATE (and normalizeGain computing a runaway
+// gain) at 11025 Hz, this project's own default sample rate.
+func TestNormalizeGainAtProjectDefaultRate(t *testing.T) {
+	const sampleRate = 11025
+	samples := make([]float64, sampleRate) // 1 second
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(2*math.Pi*440.0*float64(i)/sampleRate)
+	}
+
+	gain, lufs, _ := normalizeGain(samples, sampleRate)
+	if lufs <= ABSOLUTE_GATE {
+		t.Fatalf("measured loudness fell through to the absolute gate: %v LUFS", lufs)
+	}
+	if gain > 10 {
+		t.Fatalf("expected a modest gain for a -6dBFS tone, got %.1fx", gain)
+	}
+}
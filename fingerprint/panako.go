@@ -0,0 +1,366 @@
+package fingerprint
+
+import (
+	"log"
+)
+
+// Panako-style triplet fingerprinting: shift-invariant hashes built from
+// triplets of local-maximum peaks in the time/frequency plane.
+// ref: "Panako - A Scalable Acoustic Fingerprinting System Robust to Time-Scale
+//       Modification and Pitch-Shifting" (Six & Leman, ISMIR 2014)
+
+const (
+	PANAKO_FREQ_FILTER_WIDTH = 103 // frequency bins wide for the 2-D max filter
+	PANAKO_TIME_FILTER_WIDTH = 25  // time frames wide for the 2-D max filter
+
+	PANAKO_MIN_TIME_DELTA = 2  // frames - minimum separation between triplet peaks
+	PANAKO_MAX_TIME_DELTA = 33 // frames - maximum separation between triplet peaks
+
+	PANAKO_MIN_FREQ_DELTA = 1   // bins - minimum separation between triplet peaks
+	PANAKO_MAX_FREQ_DELTA = 128 // bins - maximum separation between triplet peaks
+
+	panakoFreqBits  = 8  // f1, as the low 8 bits of its FreqBin
+	panakoRatioBits = 12 // (f2-f1)/(f3-f1) and (t2-t1)/(t3-t1), quantized
+	panakoRatioMax  = (1 << panakoRatioBits) - 1
+)
+
+// A local-maximum event point in the time/frequency plane
+type Peak struct {
+	TimeFrame uint32
+	FreqBin   uint32
+	Magnitude float32
+}
+
+// PeakPicker accumulates spectral frames into a ring buffer and runs a 2-D
+// max filter over it to emit local-maximum Peaks, PANAKO_TIME_FILTER_WIDTH
+// frames at a time. Each PeakPicker carries state for exactly one audio
+// source - a frame from a different source fed into the same picker would
+// slide through the same ring buffer and corrupt its peaks, so callers must
+// construct one PeakPicker per source and keep feeding it that source's
+// frames only.
+type PeakPicker struct {
+	frames    [][]float64 // ring buffer of Pxx, one slice per time frame
+	freqs     []float64
+	nextFrame uint32
+}
+
+func NewPeakPicker() *PeakPicker {
+	return &PeakPicker{frames: make([][]float64, 0, PANAKO_TIME_FILTER_WIDTH)}
+}
+
+// AddFrame feeds in one spectral analysis frame and returns any Peaks found
+// once enough frames have accumulated to fill the time window. The frame
+// index of each returned Peak is relative to the start of this picker.
+func (p *PeakPicker) AddFrame(freqs, Pxx []float64) []Peak {
+	p.freqs = freqs
+	p.frames = append(p.frames, Pxx)
+	if len(p.frames) < PANAKO_TIME_FILTER_WIDTH {
+		return nil
+	}
+
+	peaks := p.maxFilter()
+	p.frames = p.frames[1:]
+	p.nextFrame++
+
+	return peaks
+}
+
+// maxFilter runs a PANAKO_TIME_FILTER_WIDTH x PANAKO_FREQ_FILTER_WIDTH max
+// filter over the buffered frames and returns every point of the middle
+// frame above LOWER_POWER_CUTOFF that no other point in its window exceeds;
+// a point equal to its largest neighbour is still returned.
+func (p *PeakPicker) maxFilter() (peaks []Peak) {
+	midT := PANAKO_TIME_FILTER_WIDTH / 2
+	fHalf := PANAKO_FREQ_FILTER_WIDTH / 2
+
+	row := p.frames[midT]
+	for fb, mag := range row {
+		if mag <= LOWER_POWER_CUTOFF {
+			continue
+		}
+
+		isMax := true
+		for dt := 0; dt < PANAKO_TIME_FILTER_WIDTH && isMax; dt++ {
+			frame := p.frames[dt]
+			lo := fb - fHalf
+			if lo < 0 {
+				lo = 0
+			}
+			hi := fb + fHalf
+			if hi >= len(frame) {
+				hi = len(frame) - 1
+			}
+			for df := lo; df <= hi; df++ {
+				if dt == midT && df == fb {
+					continue
+				}
+				if frame[df] > mag {
+					isMax = false
+					break
+				}
+			}
+		}
+
+		if isMax {
+			peaks = append(peaks, Peak{
+				TimeFrame: p.nextFrame + uint32(midT),
+				FreqBin:   uint32(fb),
+				Magnitude: float32(mag),
+			})
+		}
+	}
+
+	return
+}
+
+// tripletHash packs an ordered triplet of peaks into a single hash: the low
+// panakoFreqBits bits of p1's FreqBin, plus the frequency and time ratios of
+// the triplet, each clamped to [0, 1] and quantized to panakoRatioBits bits.
+// The ratios are unaffected by a uniform rescaling of the time or frequency
+// axis, so a time stretch that preserves them keeps the hash. f1 is stored
+// exactly (modulo 1<<panakoFreqBits), so a pitch shift that moves p1 to
+// another bin changes the hash.
+func tripletHash(p1, p2, p3 Peak) uint32 {
+	fRatio := float64(int32(p2.FreqBin)-int32(p1.FreqBin)) / float64(int32(p3.FreqBin)-int32(p1.FreqBin))
+	tRatio := float64(int32(p2.TimeFrame)-int32(p1.TimeFrame)) / float64(int32(p3.TimeFrame)-int32(p1.TimeFrame))
+
+	f1 := p1.FreqBin & (1<<panakoFreqBits - 1)
+	fq := quantizeRatio(fRatio)
+	tq := quantizeRatio(tRatio)
+
+	return f1<<(2*panakoRatioBits) | fq<<panakoRatioBits | tq
+}
+
+func quantizeRatio(r float64) uint32 {
+	if r < 0 {
+		r = 0
+	}
+	if r > 1 {
+		r = 1
+	}
+	return uint32(r*panakoRatioMax + 0.5)
+}
+
+// tripletCandidate is one (p1, p2, p3) found by triplets, paired with its
+// shift-invariant hash. Query and Add both need the raw peaks alongside the
+// hash: Add to index them, Query to estimate the time/frequency rescaling
+// between a matched query triplet and the indexed one that produced the hash.
+type tripletCandidate struct {
+	hash       uint32
+	p1, p2, p3 Peak
+}
+
+// triplets enumerates every (p1, p2, p3) in peaks whose time and frequency
+// separation fall within the configured bounds, anchored on p1.
+func triplets(peaks []Peak) (candidates []tripletCandidate) {
+	for i, p1 := range peaks {
+		for j := i + 1; j < len(peaks); j++ {
+			p2 := peaks[j]
+			dt12 := timeDelta(p1, p2)
+			if dt12 < PANAKO_MIN_TIME_DELTA || dt12 > PANAKO_MAX_TIME_DELTA {
+				continue
+			}
+			for k := j + 1; k < len(peaks); k++ {
+				p3 := peaks[k]
+				dt13 := timeDelta(p1, p3)
+				if dt13 < PANAKO_MIN_TIME_DELTA || dt13 > PANAKO_MAX_TIME_DELTA {
+					continue
+				}
+				df12 := freqDelta(p1, p2)
+				df13 := freqDelta(p1, p3)
+				if df12 < PANAKO_MIN_FREQ_DELTA || df12 > PANAKO_MAX_FREQ_DELTA {
+					continue
+				}
+				if df13 < PANAKO_MIN_FREQ_DELTA || df13 > PANAKO_MAX_FREQ_DELTA {
+					continue
+				}
+
+				candidates = append(candidates, tripletCandidate{
+					hash: tripletHash(p1, p2, p3),
+					p1:   p1, p2: p2, p3: p3,
+				})
+			}
+		}
+	}
+
+	return
+}
+
+func timeDelta(a, b Peak) uint32 {
+	if b.TimeFrame > a.TimeFrame {
+		return b.TimeFrame - a.TimeFrame
+	}
+	return a.TimeFrame - b.TimeFrame
+}
+
+func freqDelta(a, b Peak) uint32 {
+	if b.FreqBin > a.FreqBin {
+		return b.FreqBin - a.FreqBin
+	}
+	return a.FreqBin - b.FreqBin
+}
+
+// A single matched source, with the estimated alignment between the query
+// and the indexed audio.
+type Match struct {
+	SourceID   string
+	Confidence float64
+	TimeOffset float64 // seconds to add to the query timestamp to align with the source
+	TimeFactor float64 // estimated time-stretch factor of the query relative to the source
+	FreqFactor float64 // estimated pitch-shift factor of the query relative to the source
+}
+
+// tripletEntry keeps the full indexed triplet, not just its anchor - Query
+// needs p1/p2/p3 to recover how much the matching query triplet was
+// time-stretched or pitch-shifted relative to this one.
+type tripletEntry struct {
+	sourceID   string
+	p1, p2, p3 Peak
+}
+
+// Matcher indexes triplet hashes against a source ID and its full peak
+// triplet, and matches queries against that index via a tolerant histogram
+// of anchor offsets - the standard landmark-matching technique. hopSeconds
+// is the real-world duration of one Peak.TimeFrame step, needed to convert
+// the frame offsets found during matching into a TimeOffset in seconds.
+type Matcher struct {
+	index      map[uint32][]tripletEntry
+	hopSeconds float64
+}
+
+func NewMatcher(hopSeconds float64) *Matcher {
+	return &Matcher{index: make(map[uint32][]tripletEntry), hopSeconds: hopSeconds}
+}
+
+// collectPeaks flattens every fp.Peaks in fps into one slice. A single
+// Fingerprint's Peaks all share one TimeFrame (FP_PANAKO emits one
+// max-filter window at a time), so triplets() needs Peaks from many
+// Fingerprints - spanning many distinct TimeFrames - to find any candidates
+// at all; fps is expected to be every FP_PANAKO Fingerprint produced while
+// scanning one audio source (for Add) or one query clip (for Query).
+func collectPeaks(fps []*Fingerprint) (peaks []Peak) {
+	for _, fp := range fps {
+		if fp != nil {
+			peaks = append(peaks, fp.Peaks...)
+		}
+	}
+	return
+}
+
+// Add indexes every triplet hash found across fps against sourceID.
+func (m *Matcher) Add(sourceID string, fps []*Fingerprint) {
+	peaks := collectPeaks(fps)
+	if len(peaks) == 0 {
+		return
+	}
+
+	for _, c := range triplets(peaks) {
+		m.index[c.hash] = append(m.index[c.hash], tripletEntry{sourceID: sourceID, p1: c.p1, p2: c.p2, p3: c.p3})
+	}
+}
+
+// anchorBinWidth is the tolerance, in time frames, used when histogramming
+// anchor offsets between a query and an indexed source.
+const anchorBinWidth = 2
+
+// rescaling is what a single matched (query triplet, indexed entry) pair
+// says about the alignment between the query and that source: the triplet's
+// own time/frequency deltas scale linearly under time-stretch and
+// pitch-shift, so their ratio against the indexed triplet's deltas estimates
+// TimeFactor/FreqFactor directly; offsetFrames is the anchor (p1) offset.
+type rescaling struct {
+	timeFactor, freqFactor float64
+	offsetFrames           int32
+}
+
+// Query looks up every triplet hash found across fps and returns, for each
+// source that shares at least one triplet hash with the query, a Match
+// describing the offset between the query and that source, taken from its
+// most populated anchor-offset bin. There is no minimum hit count: a single
+// colliding triplet is enough to report a source.
+func (m *Matcher) Query(fps []*Fingerprint) []Match {
+	peaks := collectPeaks(fps)
+	if len(peaks) == 0 {
+		return nil
+	}
+
+	queryTriplets := triplets(peaks)
+	if len(queryTriplets) == 0 {
+		log.Printf("Matcher.Query: no triplets formed from %d peaks", len(peaks))
+		return nil
+	}
+
+	histograms := make(map[string]map[int32]int)
+	rescalings := make(map[string]map[int32][]rescaling)
+
+	for _, qc := range queryTriplets {
+		for _, entry := range m.index[qc.hash] {
+			offset := int32(entry.p1.TimeFrame) - int32(qc.p1.TimeFrame)
+			bin := offset / anchorBinWidth
+
+			hist, ok := histograms[entry.sourceID]
+			if !ok {
+				hist = make(map[int32]int)
+				histograms[entry.sourceID] = hist
+			}
+			hist[bin]++
+
+			sourceDT := float64(int32(entry.p3.TimeFrame) - int32(entry.p1.TimeFrame))
+			sourceDF := float64(int32(entry.p3.FreqBin) - int32(entry.p1.FreqBin))
+			if sourceDT == 0 || sourceDF == 0 {
+				continue
+			}
+			queryDT := float64(int32(qc.p3.TimeFrame) - int32(qc.p1.TimeFrame))
+			queryDF := float64(int32(qc.p3.FreqBin) - int32(qc.p1.FreqBin))
+
+			bySource, ok := rescalings[entry.sourceID]
+			if !ok {
+				bySource = make(map[int32][]rescaling)
+				rescalings[entry.sourceID] = bySource
+			}
+			bySource[bin] = append(bySource[bin], rescaling{
+				timeFactor:   queryDT / sourceDT,
+				freqFactor:   queryDF / sourceDF,
+				offsetFrames: offset,
+			})
+		}
+	}
+
+	var matches []Match
+	for sourceID, hist := range histograms {
+		var bestBin int32
+		var bestCount int
+		for bin, count := range hist {
+			if count > bestCount {
+				bestBin = bin
+				bestCount = count
+			}
+		}
+
+		// Average the rescaling estimated by every triplet that landed in
+		// the winning bin - they all agree on roughly the same alignment,
+		// so this cancels out per-triplet quantization noise.
+		timeFactor, freqFactor, offsetFrames := 1.0, 1.0, 0.0
+		if samples := rescalings[sourceID][bestBin]; len(samples) > 0 {
+			var sumTime, sumFreq, sumOffset float64
+			for _, s := range samples {
+				sumTime += s.timeFactor
+				sumFreq += s.freqFactor
+				sumOffset += float64(s.offsetFrames)
+			}
+			timeFactor = sumTime / float64(len(samples))
+			freqFactor = sumFreq / float64(len(samples))
+			offsetFrames = sumOffset / float64(len(samples))
+		}
+
+		matches = append(matches, Match{
+			SourceID:   sourceID,
+			Confidence: float64(bestCount) / float64(len(queryTriplets)),
+			TimeOffset: offsetFrames * m.hopSeconds,
+			TimeFactor: timeFactor,
+			FreqFactor: freqFactor,
+		})
+	}
+
+	return matches
+}
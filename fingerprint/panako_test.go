@@ -0,0 +1,124 @@
+package fingerprint
+
+import (
+	"math"
+	"testing"
+)
+
+// TestPeakPickerFindsLocalMaximum feeds a steady tone (one bin consistently
+// above its neighbours) through enough frames to fill the max-filter window
+// and checks the emitted Peaks land on that bin.
+func TestPeakPickerFindsLocalMaximum(t *testing.T) {
+	const numBins = 256
+	const peakBin = 128
+	const peakMag = 1000.0
+	const floorMag = LOWER_POWER_CUTOFF // at the cutoff, so non-peak bins are skipped as candidates entirely
+
+	freqs := make([]float64, numBins)
+	for i := range freqs {
+		freqs[i] = float64(i) * 10
+	}
+
+	picker := NewPeakPicker()
+
+	var found []Peak
+	for frame := 0; frame < PANAKO_TIME_FILTER_WIDTH+5; frame++ {
+		Pxx := make([]float64, numBins)
+		for i := range Pxx {
+			Pxx[i] = floorMag
+		}
+		Pxx[peakBin] = peakMag
+
+		found = append(found, picker.AddFrame(freqs, Pxx)...)
+	}
+
+	if len(found) == 0 {
+		t.Fatal("expected at least one peak to be found")
+	}
+	for _, p := range found {
+		if p.FreqBin != peakBin {
+			t.Errorf("got FreqBin %d, want %d", p.FreqBin, peakBin)
+		}
+		if p.Magnitude != peakMag {
+			t.Errorf("got Magnitude %v, want %v", p.Magnitude, peakMag)
+		}
+	}
+}
+
+// TestTripletsRespectsBounds checks that every triplet triplets() emits
+// actually satisfies the configured time/frequency delta bounds, using a
+// mix of peaks that are deliberately too close or too far apart to form
+// valid triplets with the first peak.
+func TestTripletsRespectsBounds(t *testing.T) {
+	peaks := []Peak{
+		{TimeFrame: 0, FreqBin: 0},
+		{TimeFrame: 10, FreqBin: 20}, // dt, df both valid vs peaks[0]
+		{TimeFrame: 25, FreqBin: 50}, // dt, df both valid vs peaks[0]
+		{TimeFrame: 1, FreqBin: 5},   // dt too small vs peaks[0]
+		{TimeFrame: 40, FreqBin: 0},  // dt too large, df too small vs peaks[0]
+	}
+
+	got := triplets(peaks)
+	if len(got) == 0 {
+		t.Fatal("expected at least one valid triplet among the peaks")
+	}
+
+	for _, c := range got {
+		if dt := timeDelta(c.p1, c.p2); dt < PANAKO_MIN_TIME_DELTA || dt > PANAKO_MAX_TIME_DELTA {
+			t.Errorf("triplet %+v has out-of-bounds dt12=%d", c, dt)
+		}
+		if dt := timeDelta(c.p1, c.p3); dt < PANAKO_MIN_TIME_DELTA || dt > PANAKO_MAX_TIME_DELTA {
+			t.Errorf("triplet %+v has out-of-bounds dt13=%d", c, dt)
+		}
+		if df := freqDelta(c.p1, c.p2); df < PANAKO_MIN_FREQ_DELTA || df > PANAKO_MAX_FREQ_DELTA {
+			t.Errorf("triplet %+v has out-of-bounds df12=%d", c, df)
+		}
+		if df := freqDelta(c.p1, c.p3); df < PANAKO_MIN_FREQ_DELTA || df > PANAKO_MAX_FREQ_DELTA {
+			t.Errorf("triplet %+v has out-of-bounds df13=%d", c, df)
+		}
+	}
+}
+
+// TestMatcherFindsTimeStretchedMatch indexes a source triplet and queries
+// with the same triplet time-stretched 2x (and shifted later in time), and
+// checks Match reports the source, the correct TimeFactor, and a TimeOffset
+// consistent with the configured hop duration.
+func TestMatcherFindsTimeStretchedMatch(t *testing.T) {
+	source := []Peak{
+		{TimeFrame: 10, FreqBin: 20, Magnitude: 500},
+		{TimeFrame: 15, FreqBin: 35, Magnitude: 500},
+		{TimeFrame: 25, FreqBin: 55, Magnitude: 500},
+	}
+	// Same triplet ratios, time deltas doubled and the whole thing shifted
+	// 40 frames later; frequency untouched (no pitch shift).
+	query := []Peak{
+		{TimeFrame: 50, FreqBin: 20, Magnitude: 500},
+		{TimeFrame: 60, FreqBin: 35, Magnitude: 500},
+		{TimeFrame: 80, FreqBin: 55, Magnitude: 500},
+	}
+
+	const hopSeconds = 0.01
+	m := NewMatcher(hopSeconds)
+	m.Add("source.wav", []*Fingerprint{{Peaks: source}})
+
+	matches := m.Query([]*Fingerprint{{Peaks: query}})
+	if len(matches) != 1 {
+		t.Fatalf("expected exactly one match, got %d: %+v", len(matches), matches)
+	}
+
+	match := matches[0]
+	if match.SourceID != "source.wav" {
+		t.Errorf("SourceID = %q, want %q", match.SourceID, "source.wav")
+	}
+	if math.Abs(match.TimeFactor-2.0) > 0.01 {
+		t.Errorf("TimeFactor = %.3f, want ~2.0 (query is time-stretched 2x relative to source)", match.TimeFactor)
+	}
+	if math.Abs(match.FreqFactor-1.0) > 0.01 {
+		t.Errorf("FreqFactor = %.3f, want ~1.0 (no pitch shift in this test)", match.FreqFactor)
+	}
+
+	wantOffset := -40 * hopSeconds // source p1 (frame 10) - query p1 (frame 50)
+	if math.Abs(match.TimeOffset-wantOffset) > 2*hopSeconds {
+		t.Errorf("TimeOffset = %.3fs, want ~%.3fs", match.TimeOffset, wantOffset)
+	}
+}
@@ -0,0 +1,120 @@
+package fingerprint
+
+import "math"
+
+// Harmonic-peak refinement: fuzzyFreq's nearest-10Hz rounding hides FFT bin
+// quantization, but that quantization can span multiple semitones at low
+// frequencies, which destroys pitch precision. refinePeaks recovers
+// sub-bin accuracy via parabolic interpolation around each local maximum,
+// and rejects peaks that don't stand out from the surrounding noise floor.
+
+const (
+	CENTS_PER_OCTAVE          = 1200.0
+	HARMONIC_WINDOW_CENTS     = 100.0 // window around a peak used for the noise-floor check
+	HARMONIC_ZSCORE_THRESHOLD = 2.0   // minimum standard deviations above the local mean
+	HARMONIC_MIN_WINDOW_BINS  = 5     // floor on window size so low-frequency linear bins still get a real noise estimate
+)
+
+// A spectral peak refined via parabolic interpolation
+type refinedPeak struct {
+	Freq float64
+	Pxx  float64
+}
+
+// refinePeaks finds every local maximum in Pxx and refines its frequency and
+// magnitude via parabolic interpolation:
+//   delta = 0.5*(P[k-1] - P[k+1]) / (P[k-1] - 2*P[k] + P[k+1])
+//   f     = freqs[k] + delta*(freqs[k+1]-freqs[k])   (delta > 0)
+//   f     = freqs[k] + delta*(freqs[k]-freqs[k-1])   (delta < 0)
+//   P     = P[k] - 0.25*(P[k-1]-P[k+1])*delta
+// Peaks that fail a z-score test against the bins within
+// HARMONIC_WINDOW_CENTS are rejected as noise-floor wobble.
+func refinePeaks(freqs, Pxx []float64) []refinedPeak {
+	var peaks []refinedPeak
+
+	for k := 1; k < len(Pxx)-1; k++ {
+		if Pxx[k] <= Pxx[k-1] || Pxx[k] <= Pxx[k+1] {
+			continue
+		}
+
+		denom := Pxx[k-1] - 2*Pxx[k] + Pxx[k+1]
+		var delta float64
+		if denom != 0 {
+			delta = 0.5 * (Pxx[k-1] - Pxx[k+1]) / denom
+		}
+
+		f := freqs[k]
+		switch {
+		case delta > 0:
+			f = freqs[k] + delta*(freqs[k+1]-freqs[k])
+		case delta < 0:
+			f = freqs[k] + delta*(freqs[k]-freqs[k-1])
+		}
+
+		mag := Pxx[k] - 0.25*(Pxx[k-1]-Pxx[k+1])*delta
+
+		if !standsAboveNoiseFloor(freqs, Pxx, k, mag) {
+			continue
+		}
+
+		peaks = append(peaks, refinedPeak{Freq: f, Pxx: mag})
+	}
+
+	return peaks
+}
+
+// standsAboveNoiseFloor rejects peaks that aren't a meaningful number of
+// standard deviations above the mean magnitude of the bins within
+// HARMONIC_WINDOW_CENTS of freqs[k], widened to HARMONIC_MIN_WINDOW_BINS
+// bins when the cents window is narrower than the bin spacing - which
+// happens at low frequencies on a linearly-spaced FFT rather than a CQT.
+func standsAboveNoiseFloor(freqs, Pxx []float64, k int, peakMag float64) bool {
+	lo := freqs[k] * math.Pow(2, -HARMONIC_WINDOW_CENTS/CENTS_PER_OCTAVE)
+	hi := freqs[k] * math.Pow(2, HARMONIC_WINDOW_CENTS/CENTS_PER_OCTAVE)
+
+	loIdx, hiIdx := k, k
+	for loIdx > 0 && freqs[loIdx-1] >= lo {
+		loIdx--
+	}
+	for hiIdx < len(freqs)-1 && freqs[hiIdx+1] <= hi {
+		hiIdx++
+	}
+	for hiIdx-loIdx+1 < HARMONIC_MIN_WINDOW_BINS && (loIdx > 0 || hiIdx < len(freqs)-1) {
+		if loIdx > 0 {
+			loIdx--
+		}
+		if hiIdx < len(freqs)-1 {
+			hiIdx++
+		}
+	}
+
+	var sum, sumSq float64
+	n := hiIdx - loIdx + 1
+	for i := loIdx; i <= hiIdx; i++ {
+		sum += Pxx[i]
+		sumSq += Pxx[i] * Pxx[i]
+	}
+	if n < 2 {
+		return true
+	}
+
+	mean := sum / float64(n)
+	variance := sumSq/float64(n) - mean*mean
+	if variance <= 0 {
+		return true
+	}
+
+	return (peakMag-mean)/math.Sqrt(variance) >= HARMONIC_ZSCORE_THRESHOLD
+}
+
+// peaksToSlices unpacks refined peaks back into the freqs/Pxx slice pair
+// that transcribe/getTopCandidates/getBandedCandidates expect.
+func peaksToSlices(peaks []refinedPeak) (freqs, Pxx []float64) {
+	freqs = make([]float64, len(peaks))
+	Pxx = make([]float64, len(peaks))
+	for i, p := range peaks {
+		freqs[i] = p.Freq
+		Pxx[i] = p.Pxx
+	}
+	return
+}
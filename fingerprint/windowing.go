@@ -0,0 +1,54 @@
+package fingerprint
+
+import "math"
+
+// Window function applied to a sample buffer before spectral analysis, to
+// reduce spectral leakage from the implicit rectangular window of a raw FFT.
+const (
+	WINDOW_NONE = iota
+	WINDOW_HANN = iota
+	WINDOW_HAMMING = iota
+)
+
+// preprocess subtracts the DC offset (the buffer mean) and applies the
+// requested window function, ahead of PwelchAnalysis/OverlapAnalysis. Per
+// the usual FFT practice, this keeps the DC bin and leakage sidelobes from
+// contaminating freqNote's note assignment.
+func preprocess(samples []float64, windowKind int) []float64 {
+	out := removeDC(samples)
+	applyWindow(out, windowKind)
+	return out
+}
+
+func removeDC(samples []float64) []float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += s
+	}
+	mean := sum / float64(len(samples))
+
+	out := make([]float64, len(samples))
+	for i, s := range samples {
+		out[i] = s - mean
+	}
+	return out
+}
+
+// applyWindow scales samples in place by the chosen window function.
+func applyWindow(samples []float64, windowKind int) {
+	n := len(samples)
+	switch windowKind {
+	case WINDOW_HANN:
+		for i := range samples {
+			samples[i] *= 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+		}
+	case WINDOW_HAMMING:
+		for i := range samples {
+			samples[i] *= 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+		}
+	case WINDOW_NONE:
+		// no-op: rectangular window
+	default:
+		panic("fingerprint: unrecognised window kind")
+	}
+}
@@ -0,0 +1,64 @@
+package fingerprint
+
+import (
+	"math"
+	"testing"
+)
+
+// naiveDFT is a simple O(n^2) DFT, good enough for a one-off unit test
+// without pulling in the analysis package's FFT machinery.
+func naiveDFT(samples []float64, sampleRate int) (freqs, Pxx []float64) {
+	n := len(samples)
+	freqs = make([]float64, n/2)
+	Pxx = make([]float64, n/2)
+	for k := range freqs {
+		var re, im float64
+		for i, s := range samples {
+			theta := -2 * math.Pi * float64(k) * float64(i) / float64(n)
+			re += s * math.Cos(theta)
+			im += s * math.Sin(theta)
+		}
+		freqs[k] = float64(k) * float64(sampleRate) / float64(n)
+		Pxx[k] = re*re + im*im
+	}
+	return
+}
+
+// TestPreprocessConcentratesEnergyInANote checks that windowing a pure
+// 440 Hz tone keeps its energy in the A-note bin rather than bleeding into
+// neighbouring semitones via spectral leakage.
+func TestPreprocessConcentratesEnergyInANote(t *testing.T) {
+	const sampleRate = 8000
+	const n = 1024
+
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = math.Sin(2 * math.Pi * 440.0 * float64(i) / sampleRate)
+	}
+
+	energyInANote := func(processed []float64) float64 {
+		freqs, Pxx := naiveDFT(processed, sampleRate)
+
+		var total, aNote float64
+		for i, f := range freqs {
+			if f < 20 { // skip DC/near-DC
+				continue
+			}
+			total += Pxx[i]
+			if freqNote(f) == A_NOTE {
+				aNote += Pxx[i]
+			}
+		}
+		return aNote / total
+	}
+
+	rectangular := energyInANote(append([]float64(nil), samples...))
+	if rectangular >= 0.95 {
+		t.Fatalf("expected the unwindowed tone to leak outside the A-note bin, got %.1f%% concentration", rectangular*100)
+	}
+
+	windowed := energyInANote(preprocess(samples, WINDOW_HANN))
+	if windowed < 0.95 {
+		t.Errorf("expected >95%% of energy in the A-note bin after windowing, got %.1f%%", windowed*100)
+	}
+}